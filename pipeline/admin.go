@@ -0,0 +1,162 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2012
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+package pipeline
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"net"
+	"os"
+	"syscall"
+
+	"google.golang.org/grpc"
+)
+
+// AdminServer is the gRPC control plane for a running hekad. It's the
+// canonical mechanism for runtime reconfiguration and stats; SIGHUP/SIGINT
+// remain supported as a compatibility path for operators without gRPC
+// access, but orchestrators (k8s, nomad) should talk to this instead.
+type AdminServer struct {
+	config   **PipelineConfig
+	reload   *reloader
+	grpcSrv  *grpc.Server
+	listener net.Listener
+
+	// sigChan is Run's own signal channel. Stop writes a synthetic SIGINT
+	// into it so the RPC drives the exact same sigListener/drain path a
+	// real SIGINT does, rather than just broadcasting the STOP event and
+	// leaving hekad running.
+	sigChan chan<- os.Signal
+}
+
+// StartAdminServer starts listening on addr and begins serving admin RPCs
+// in a background goroutine. configRef is a pointer to Run's live config
+// variable so a successful Reload is visible to every RPC after it.
+// sigChan is Run's signal channel; the Stop RPC writes into it to trigger
+// the same shutdown path as an operator's SIGINT. Call Close to shut it
+// down.
+func StartAdminServer(configRef **PipelineConfig, reload *reloader, addr string, sigChan chan<- os.Signal) (*AdminServer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	admin := &AdminServer{
+		config:   configRef,
+		reload:   reload,
+		grpcSrv:  grpc.NewServer(),
+		listener: listener,
+		sigChan:  sigChan,
+	}
+	RegisterAdminServer(admin.grpcSrv, admin)
+
+	go func() {
+		if err := admin.grpcSrv.Serve(listener); err != nil {
+			log.Printf("Admin server stopped serving: %s\n", err)
+		}
+	}()
+
+	return admin, nil
+}
+
+// Close gracefully stops the gRPC server and closes its listener. Named
+// Close rather than Stop since Stop is the Admin RPC that drains hekad
+// itself (AdminServiceServer.Stop).
+func (a *AdminServer) Close() {
+	a.grpcSrv.GracefulStop()
+}
+
+func (a *AdminServer) Reload(ctx context.Context, req *ReloadRequest) (*ReloadResponse, error) {
+	if err := a.reload.Reload(a.config); err != nil {
+		return &ReloadResponse{Ok: false, Error: err.Error()}, nil
+	}
+	return &ReloadResponse{Ok: true}, nil
+}
+
+func (a *AdminServer) Stop(ctx context.Context, req *StopRequest) (*StopResponse, error) {
+	BroadcastEvent(*a.config, STOP)
+	// Drive the real two-phase shutdown in Run, the same as a SIGINT: the
+	// STOP event above is just a courtesy notice to plugins that honor it,
+	// not the thing that actually drains and exits hekad.
+	a.sigChan <- syscall.SIGINT
+	return &StopResponse{Ok: true}, nil
+}
+
+func (a *AdminServer) ReloadPlugin(ctx context.Context, req *ReloadPluginRequest) (*ReloadPluginResponse, error) {
+	if err := SendPluginEvent(*a.config, req.Name, RELOAD); err != nil {
+		return &ReloadPluginResponse{Ok: false, Error: err.Error()}, nil
+	}
+	return &ReloadPluginResponse{Ok: true}, nil
+}
+
+func (a *AdminServer) ListPlugins(ctx context.Context, req *ListPluginsRequest) (*ListPluginsResponse, error) {
+	config := *a.config
+	resp := &ListPluginsResponse{}
+	for name := range config.Inputs {
+		resp.Inputs = append(resp.Inputs, name)
+	}
+	for name := range config.Decoders {
+		resp.Decoders = append(resp.Decoders, name)
+	}
+	for name := range config.Filters {
+		resp.Filters = append(resp.Filters, name)
+	}
+	for name := range config.Outputs {
+		resp.Outputs = append(resp.Outputs, name)
+	}
+	return resp, nil
+}
+
+func (a *AdminServer) Stats(ctx context.Context, req *StatsRequest) (*StatsResponse, error) {
+	resp := &StatsResponse{
+		InputMessageCounts:     expvarMapToInt64Map("PipelineInputMessageCounts"),
+		FilterChainMatchCounts: expvarMapToInt64Map("PipelineFilterChainMatchCounts"),
+		OutputDeliveryCounts:   expvarMapToInt64Map("PipelineOutputDeliveryCounts"),
+		OutputErrorCounts:      expvarMapToInt64Map("PipelineOutputErrorCounts"),
+	}
+
+	if v := expvar.Get("PipelinePoolSize"); v != nil {
+		if f, ok := v.(expvar.Func); ok {
+			if size, ok := f().(int); ok {
+				resp.PoolSize = int32(size)
+			}
+		}
+	}
+	if v := expvar.Get("PipelineQueueDepth"); v != nil {
+		if iv, ok := v.(*expvar.Int); ok {
+			resp.QueueDepth = int32(iv.Value())
+		}
+	}
+	return resp, nil
+}
+
+// expvarMapToInt64Map reads out an *expvar.Map of *expvar.Int counters
+// published under name into a plain map, the shape the Stats RPC response
+// needs. Missing or mistyped entries are skipped rather than panicking,
+// since expvar is a loosely-typed global registry.
+func expvarMapToInt64Map(name string) map[string]int64 {
+	out := map[string]int64{}
+	v := expvar.Get(name)
+	if v == nil {
+		return out
+	}
+	m, ok := v.(*expvar.Map)
+	if !ok {
+		return out
+	}
+	m.Do(func(kv expvar.KeyValue) {
+		if iv, ok := kv.Value.(*expvar.Int); ok {
+			out[kv.Key] = iv.Value()
+		}
+	})
+	return out
+}
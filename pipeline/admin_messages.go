@@ -0,0 +1,211 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2012
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+package pipeline
+
+// Hand-written stand-in for the code `protoc --go_out --go-grpc_out`
+// would generate from admin.proto. There's no protoc step in this build
+// yet, so the request/response messages and the service registration
+// glue below are written out by hand; once protoc is wired in, this file
+// should be deleted in favor of the generated admin.pb.go/admin_grpc.pb.go.
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+)
+
+type ReloadRequest struct{}
+
+func (m *ReloadRequest) Reset()         { *m = ReloadRequest{} }
+func (m *ReloadRequest) String() string { return "ReloadRequest{}" }
+func (m *ReloadRequest) ProtoMessage()  {}
+
+type ReloadResponse struct {
+	Ok    bool
+	Error string
+}
+
+func (m *ReloadResponse) Reset()         { *m = ReloadResponse{} }
+func (m *ReloadResponse) String() string { return fmt.Sprintf("%+v", *m) }
+func (m *ReloadResponse) ProtoMessage()  {}
+
+type StopRequest struct{}
+
+func (m *StopRequest) Reset()         { *m = StopRequest{} }
+func (m *StopRequest) String() string { return "StopRequest{}" }
+func (m *StopRequest) ProtoMessage()  {}
+
+type StopResponse struct {
+	Ok bool
+}
+
+func (m *StopResponse) Reset()         { *m = StopResponse{} }
+func (m *StopResponse) String() string { return fmt.Sprintf("%+v", *m) }
+func (m *StopResponse) ProtoMessage()  {}
+
+type ReloadPluginRequest struct {
+	Name string
+}
+
+func (m *ReloadPluginRequest) Reset()         { *m = ReloadPluginRequest{} }
+func (m *ReloadPluginRequest) String() string { return fmt.Sprintf("%+v", *m) }
+func (m *ReloadPluginRequest) ProtoMessage()  {}
+
+type ReloadPluginResponse struct {
+	Ok    bool
+	Error string
+}
+
+func (m *ReloadPluginResponse) Reset()         { *m = ReloadPluginResponse{} }
+func (m *ReloadPluginResponse) String() string { return fmt.Sprintf("%+v", *m) }
+func (m *ReloadPluginResponse) ProtoMessage()  {}
+
+type ListPluginsRequest struct{}
+
+func (m *ListPluginsRequest) Reset()         { *m = ListPluginsRequest{} }
+func (m *ListPluginsRequest) String() string { return "ListPluginsRequest{}" }
+func (m *ListPluginsRequest) ProtoMessage()  {}
+
+type ListPluginsResponse struct {
+	Inputs   []string
+	Decoders []string
+	Filters  []string
+	Outputs  []string
+}
+
+func (m *ListPluginsResponse) Reset()         { *m = ListPluginsResponse{} }
+func (m *ListPluginsResponse) String() string { return fmt.Sprintf("%+v", *m) }
+func (m *ListPluginsResponse) ProtoMessage()  {}
+
+type StatsRequest struct{}
+
+func (m *StatsRequest) Reset()         { *m = StatsRequest{} }
+func (m *StatsRequest) String() string { return "StatsRequest{}" }
+func (m *StatsRequest) ProtoMessage()  {}
+
+type StatsResponse struct {
+	PoolSize               int32
+	QueueDepth             int32
+	InputMessageCounts     map[string]int64
+	FilterChainMatchCounts map[string]int64
+	OutputDeliveryCounts   map[string]int64
+	OutputErrorCounts      map[string]int64
+}
+
+func (m *StatsResponse) Reset()         { *m = StatsResponse{} }
+func (m *StatsResponse) String() string { return fmt.Sprintf("%+v", *m) }
+func (m *StatsResponse) ProtoMessage()  {}
+
+// AdminServiceServer is the server-side interface admin.proto's Admin
+// service compiles down to.
+type AdminServiceServer interface {
+	Reload(context.Context, *ReloadRequest) (*ReloadResponse, error)
+	Stop(context.Context, *StopRequest) (*StopResponse, error)
+	ReloadPlugin(context.Context, *ReloadPluginRequest) (*ReloadPluginResponse, error)
+	ListPlugins(context.Context, *ListPluginsRequest) (*ListPluginsResponse, error)
+	Stats(context.Context, *StatsRequest) (*StatsResponse, error)
+}
+
+func _Admin_Reload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReloadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).Reload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/heka.admin.Admin/Reload"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).Reload(ctx, req.(*ReloadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_Stop_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StopRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).Stop(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/heka.admin.Admin/Stop"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).Stop(ctx, req.(*StopRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ReloadPlugin_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReloadPluginRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).ReloadPlugin(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/heka.admin.Admin/ReloadPlugin"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).ReloadPlugin(ctx, req.(*ReloadPluginRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ListPlugins_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListPluginsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).ListPlugins(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/heka.admin.Admin/ListPlugins"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).ListPlugins(ctx, req.(*ListPluginsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_Stats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).Stats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/heka.admin.Admin/Stats"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).Stats(ctx, req.(*StatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Admin_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "heka.admin.Admin",
+	HandlerType: (*AdminServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Reload", Handler: _Admin_Reload_Handler},
+		{MethodName: "Stop", Handler: _Admin_Stop_Handler},
+		{MethodName: "ReloadPlugin", Handler: _Admin_ReloadPlugin_Handler},
+		{MethodName: "ListPlugins", Handler: _Admin_ListPlugins_Handler},
+		{MethodName: "Stats", Handler: _Admin_Stats_Handler},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "admin.proto",
+}
+
+// RegisterAdminServer registers an AdminServiceServer implementation
+// against s, the same as the generated code from admin.proto would.
+func RegisterAdminServer(s *grpc.Server, srv AdminServiceServer) {
+	s.RegisterService(&_Admin_serviceDesc, srv)
+}
@@ -0,0 +1,175 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2012
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+package pipeline
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+)
+
+// Cascade strategies supported by MultiDecoder.
+const (
+	// Try each sub-decoder in order, stop at the first success.
+	FirstWinsStrategy = "first-wins"
+	// Run every sub-decoder and merge whatever they each manage to
+	// produce into the pack's Message.
+	AllStrategy = "all"
+)
+
+// MultiDecoderConfig is the TOML-mapped config struct for a MultiDecoder
+// plugin instance.
+type MultiDecoderConfig struct {
+	Subs            []string `toml:"subs"`
+	CascadeStrategy string   `toml:"cascade_strategy"`
+}
+
+// MultiDecoder tries an ordered list of sub-decoders against a pack's raw
+// MsgBytes, so a single input can accept a mixed-format stream (protobuf,
+// JSON, plain text, ...) without being partitioned per format.
+type MultiDecoder struct {
+	subs            []string
+	decoders        map[string]Decoder
+	cascadeStrategy string
+}
+
+func (md *MultiDecoder) Init(config interface{}) error {
+	conf := config.(*MultiDecoderConfig)
+	if len(conf.Subs) == 0 {
+		return errors.New("MultiDecoder requires at least one sub-decoder in `subs`")
+	}
+	md.subs = conf.Subs
+	md.cascadeStrategy = conf.CascadeStrategy
+	if md.cascadeStrategy == "" {
+		md.cascadeStrategy = FirstWinsStrategy
+	}
+	if md.cascadeStrategy != FirstWinsStrategy && md.cascadeStrategy != AllStrategy {
+		return errors.New("cascade_strategy must be \"first-wins\" or \"all\"")
+	}
+	return nil
+}
+
+// SetDecoders wires the MultiDecoder up to the already-initialized
+// sub-decoders it was configured to wrap, keyed by name. Called once per
+// PipelinePack alongside InitDecoders.
+func (md *MultiDecoder) SetDecoders(decoders map[string]Decoder) {
+	md.decoders = decoders
+}
+
+// Decode implements the Decoder interface. It resets pack.MsgBytes to the
+// original slice before each sub-decoder attempt, so a prior failed attempt
+// that resliced it doesn't affect the next one. The underlying bytes are
+// shared, not copied.
+func (md *MultiDecoder) Decode(pack *PipelinePack) error {
+	origMsgBytes := pack.MsgBytes
+
+	if md.cascadeStrategy == AllStrategy {
+		return md.decodeAll(pack, origMsgBytes)
+	}
+	return md.decodeFirstWins(pack, origMsgBytes)
+}
+
+func (md *MultiDecoder) decodeFirstWins(pack *PipelinePack, origMsgBytes []byte) error {
+	var errs []string
+
+	for _, name := range md.subs {
+		decoder, ok := md.decoders[name]
+		if !ok {
+			errs = append(errs, name+": not configured")
+			continue
+		}
+
+		pack.MsgBytes = origMsgBytes
+		pack.Zero()
+		pack.MsgBytes = origMsgBytes
+
+		if err := decoder.Decode(pack); err != nil {
+			errs = append(errs, name+": "+err.Error())
+			continue
+		}
+		if !isWellFormed(pack.Message) {
+			errs = append(errs, name+": decoded message missing required fields")
+			continue
+		}
+		pack.Decoded = true
+		return nil
+	}
+
+	// A single aggregated error rather than one log line per failed
+	// sub-decoder; the caller (pipeline.Run's decode stage) logs it once.
+	return errors.New("MultiDecoder: no sub-decoder could decode the message: " +
+		strings.Join(errs, "; "))
+}
+
+// decodeAll runs every sub-decoder against the original bytes and merges
+// their results into a single Message: later sub-decoders in `subs` order
+// win on a field-by-field basis, but only where they actually set a
+// non-zero value, so an earlier decoder's fields survive a later decoder
+// that didn't populate them.
+func (md *MultiDecoder) decodeAll(pack *PipelinePack, origMsgBytes []byte) error {
+	merged := &Message{}
+	succeeded := false
+	var errs []string
+
+	for _, name := range md.subs {
+		decoder, ok := md.decoders[name]
+		if !ok {
+			errs = append(errs, name+": not configured")
+			continue
+		}
+
+		pack.MsgBytes = origMsgBytes
+		*pack.Message = Message{}
+		if err := decoder.Decode(pack); err != nil {
+			errs = append(errs, name+": "+err.Error())
+			continue
+		}
+		mergeMessageFields(merged, pack.Message)
+		succeeded = true
+	}
+
+	pack.MsgBytes = origMsgBytes
+	if !succeeded {
+		return errors.New("MultiDecoder: no sub-decoder could decode the message: " +
+			strings.Join(errs, "; "))
+	}
+	*pack.Message = *merged
+	pack.Decoded = true
+	return nil
+}
+
+// mergeMessageFields copies every non-zero exported field from src onto
+// dst. It's generic over Message's actual field set so MultiDecoder
+// doesn't need to know the schema of whatever heka/message.Message looks
+// like in a given build.
+func mergeMessageFields(dst, src *Message) {
+	if dst == nil || src == nil {
+		return
+	}
+	dv := reflect.ValueOf(dst).Elem()
+	sv := reflect.ValueOf(src).Elem()
+	for i := 0; i < sv.NumField(); i++ {
+		sf := sv.Field(i)
+		if !sf.CanInterface() || sf.IsZero() {
+			continue
+		}
+		df := dv.Field(i)
+		if df.CanSet() {
+			df.Set(sf)
+		}
+	}
+}
+
+// isWellFormed reports whether a decode attempt actually produced a usable
+// message rather than leaving Message in its zero state.
+func isWellFormed(msg *Message) bool {
+	return msg != nil && msg.GetTimestamp() != 0
+}
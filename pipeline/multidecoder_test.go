@@ -0,0 +1,185 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2012
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+package pipeline
+
+import (
+	"errors"
+	"testing"
+)
+
+// fakeDecoder lets a test script exactly what a sub-decoder does to the
+// pack it's handed, without depending on any real wire format.
+type fakeDecoder struct {
+	decode func(pack *PipelinePack) error
+}
+
+func (f *fakeDecoder) Decode(pack *PipelinePack) error {
+	return f.decode(pack)
+}
+
+// timestamp and loggerName are one-off helpers: Message's Timestamp and
+// Logger fields are *int64/*string, matching the GetTimestamp()/GetLogger()
+// getters isWellFormed and the merge test rely on.
+func timestamp(ts int64) *int64 {
+	return &ts
+}
+
+func loggerName(name string) *string {
+	return &name
+}
+
+func newTestPack(msgBytes []byte) *PipelinePack {
+	return &PipelinePack{
+		MsgBytes: msgBytes,
+		Message:  &Message{},
+		Config:   &PipelineConfig{},
+		Decoders: map[string]Decoder{},
+	}
+}
+
+func newMultiDecoder(t *testing.T, strategy string, subs ...string) *MultiDecoder {
+	md := &MultiDecoder{}
+	err := md.Init(&MultiDecoderConfig{Subs: subs, CascadeStrategy: strategy})
+	if err != nil {
+		t.Fatalf("Init returned unexpected error: %s", err)
+	}
+	return md
+}
+
+func TestMultiDecoderFirstWinsPartialDecode(t *testing.T) {
+	pack := newTestPack([]byte("orig"))
+	md := newMultiDecoder(t, FirstWinsStrategy, "a", "b")
+	md.SetDecoders(map[string]Decoder{
+		"a": &fakeDecoder{decode: func(pack *PipelinePack) error {
+			return errors.New("a: malformed input")
+		}},
+		"b": &fakeDecoder{decode: func(pack *PipelinePack) error {
+			pack.Message.Timestamp = timestamp(42)
+			return nil
+		}},
+	})
+
+	if err := md.Decode(pack); err != nil {
+		t.Fatalf("expected the second sub-decoder to succeed, got: %s", err)
+	}
+	if !pack.Decoded {
+		t.Fatal("expected pack.Decoded to be true after a successful sub-decode")
+	}
+	if pack.Message.GetTimestamp() != 42 {
+		t.Fatalf("expected the successful sub-decoder's message to win, got timestamp %d",
+			pack.Message.GetTimestamp())
+	}
+}
+
+func TestMultiDecoderRestoresMsgBytesBetweenAttempts(t *testing.T) {
+	orig := []byte("original-bytes")
+	pack := newTestPack(orig)
+	md := newMultiDecoder(t, FirstWinsStrategy, "a", "b")
+
+	var seenByB []byte
+	md.SetDecoders(map[string]Decoder{
+		"a": &fakeDecoder{decode: func(pack *PipelinePack) error {
+			// Simulate a sub-decoder that consumes/truncates the buffer
+			// before failing.
+			pack.MsgBytes = pack.MsgBytes[:3]
+			return errors.New("a: truncated, bailing")
+		}},
+		"b": &fakeDecoder{decode: func(pack *PipelinePack) error {
+			seenByB = pack.MsgBytes
+			pack.Message.Timestamp = timestamp(1)
+			return nil
+		}},
+	})
+
+	if err := md.Decode(pack); err != nil {
+		t.Fatalf("expected decode to succeed, got: %s", err)
+	}
+	if string(seenByB) != string(orig) {
+		t.Fatalf("expected sub-decoder b to see the original bytes %q, got %q",
+			orig, seenByB)
+	}
+}
+
+func TestMultiDecoderZeroResetBetweenAttempts(t *testing.T) {
+	pack := newTestPack([]byte("orig"))
+	md := newMultiDecoder(t, FirstWinsStrategy, "a", "b")
+
+	var sawBlockedInB bool
+	md.SetDecoders(map[string]Decoder{
+		"a": &fakeDecoder{decode: func(pack *PipelinePack) error {
+			// Leave behind state a naive implementation might let leak
+			// into the next sub-decoder's attempt.
+			pack.Blocked = true
+			pack.Decoded = true
+			return errors.New("a: failed after partially processing")
+		}},
+		"b": &fakeDecoder{decode: func(pack *PipelinePack) error {
+			sawBlockedInB = pack.Blocked
+			pack.Message.Timestamp = timestamp(7)
+			return nil
+		}},
+	})
+
+	if err := md.Decode(pack); err != nil {
+		t.Fatalf("expected decode to succeed, got: %s", err)
+	}
+	if sawBlockedInB {
+		t.Fatal("expected pack.Zero() between attempts to clear Blocked before sub-decoder b ran")
+	}
+}
+
+func TestMultiDecoderAllStrategyMergesFields(t *testing.T) {
+	pack := newTestPack([]byte("orig"))
+	md := newMultiDecoder(t, AllStrategy, "a", "b")
+	md.SetDecoders(map[string]Decoder{
+		"a": &fakeDecoder{decode: func(pack *PipelinePack) error {
+			pack.Message.Timestamp = timestamp(1)
+			pack.Message.Logger = loggerName("a-logger")
+			return nil
+		}},
+		"b": &fakeDecoder{decode: func(pack *PipelinePack) error {
+			// b only sets Timestamp; a's Logger should survive the merge.
+			pack.Message.Timestamp = timestamp(2)
+			return nil
+		}},
+	})
+
+	if err := md.Decode(pack); err != nil {
+		t.Fatalf("expected decode to succeed, got: %s", err)
+	}
+	if pack.Message.GetTimestamp() != 2 {
+		t.Fatalf("expected the later sub-decoder's Timestamp to win, got %d",
+			pack.Message.GetTimestamp())
+	}
+	if pack.Message.GetLogger() != "a-logger" {
+		t.Fatalf("expected a's Logger to survive the merge, got %q", pack.Message.GetLogger())
+	}
+}
+
+func TestMultiDecoderAllSubDecodersFail(t *testing.T) {
+	pack := newTestPack([]byte("orig"))
+	md := newMultiDecoder(t, FirstWinsStrategy, "a", "b")
+	md.SetDecoders(map[string]Decoder{
+		"a": &fakeDecoder{decode: func(pack *PipelinePack) error {
+			return errors.New("a: nope")
+		}},
+		"b": &fakeDecoder{decode: func(pack *PipelinePack) error {
+			return errors.New("b: nope either")
+		}},
+	})
+
+	if err := md.Decode(pack); err == nil {
+		t.Fatal("expected an aggregated error when every sub-decoder fails")
+	}
+	if pack.Decoded {
+		t.Fatal("expected pack.Decoded to remain false when every sub-decoder fails")
+	}
+}
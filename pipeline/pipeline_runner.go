@@ -14,12 +14,17 @@
 package pipeline
 
 import (
+	"context"
+	"errors"
+	"expvar"
 	"github.com/rafrombrc/go-notify"
 	. "heka/message"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -30,8 +35,71 @@ const (
 	STOP   = "stop"
 )
 
+// Fallback worker count used when `hekad.max_procs` isn't set in the TOML
+// config (PipelineConfig.MaxProcs <= 0).
+const DefaultMaxProcs = 4
+
+// Fallback drain deadline used when `hekad.shutdown_timeout` isn't set in
+// the TOML config (PipelineConfig.ShutdownTimeout <= 0).
+const DefaultShutdownTimeout = 5 * time.Second
+
+// Fallback retry ceiling used when `hekad.retry_limit` isn't set in the
+// TOML config (PipelineConfig.RetryLimit <= 0). Deliberately small; this is
+// a backoff before falling back to the dead letter output, not a promise
+// to eventually deliver.
+const DefaultRetryLimit = 3
+
+// Base delay for the exponential backoff applied between retried
+// deliveries; actual delay is baseRetryDelay * 2^(RetryCount-1), capped at
+// maxRetryDelay (see retryBackoff).
+const baseRetryDelay = 100 * time.Millisecond
+
+// Ceiling for the exponential backoff delay. retry_limit is operator
+// configurable, and baseRetryDelay's shift would otherwise overflow
+// time.Duration (an int64 count of nanoseconds) well before reaching a
+// large retry_limit, yielding a wrapped-around zero/negative delay.
+const maxRetryDelay = 30 * time.Second
+
+// retryBackoff returns the delay before retry attempt n (n > 0), clamped
+// to maxRetryDelay so a large operator-configured retry_limit can't shift
+// baseRetryDelay past what time.Duration can represent.
+func retryBackoff(n int) time.Duration {
+	if n > 16 { // 1<<16 already exceeds maxRetryDelay; avoid shifting further
+		return maxRetryDelay
+	}
+	if delay := baseRetryDelay << uint(n-1); delay > 0 && delay < maxRetryDelay {
+		return delay
+	}
+	return maxRetryDelay
+}
+
 var PoolSize int
 
+// Per-plugin counters backing the admin Stats RPC (chunk0-5). Package
+// level because filterProcessor isn't a Run() closure and has no other
+// way to reach them.
+var (
+	inputMessageCounts     = expvar.NewMap("PipelineInputMessageCounts")
+	filterChainMatchCounts = expvar.NewMap("PipelineFilterChainMatchCounts")
+	outputDeliveryCounts   = expvar.NewMap("PipelineOutputDeliveryCounts")
+	outputErrorCounts      = expvar.NewMap("PipelineOutputErrorCounts")
+)
+
+// workerStats tracks per-worker busy/idle state so it can be published via
+// expvar without the workers themselves knowing about the reporting format.
+type workerStats struct {
+	busy int32
+}
+
+func (w *workerStats) markBusy() { atomic.StoreInt32(&w.busy, 1) }
+func (w *workerStats) markIdle() { atomic.StoreInt32(&w.busy, 0) }
+func (w *workerStats) String() string {
+	if atomic.LoadInt32(&w.busy) == 1 {
+		return `"busy"`
+	}
+	return `"idle"`
+}
+
 type Plugin interface {
 	Init(config interface{}) error
 }
@@ -59,6 +127,13 @@ type PipelinePack struct {
 	FilterChain string
 	ChainCount  int
 	OutputNames map[string]bool
+	RetryCount  int
+	LastError   error
+
+	// InputName is set by the owning InputRunner before a pack is pushed
+	// onto pipelineChan, so the worker pool can attribute it for the
+	// PipelineInputMessageCounts stat.
+	InputName string
 }
 
 func NewPipelinePack(config *PipelineConfig) *PipelinePack {
@@ -90,7 +165,15 @@ func NewPipelinePack(config *PipelineConfig) *PipelinePack {
 
 func (self *PipelinePack) InitDecoders(config *PipelineConfig) {
 	for name, wrapper := range config.Decoders {
-		self.Decoders[name] = wrapper.Create().(Decoder)
+		decoder := wrapper.Create().(Decoder)
+		self.Decoders[name] = decoder
+		// MultiDecoder dispatches to the pack's other sub-decoders by
+		// name, so it needs to see the very map it's being registered
+		// into. Handing it self.Decoders (rather than a copy) means it
+		// also picks up sub-decoders added later in this same loop.
+		if md, ok := decoder.(*MultiDecoder); ok {
+			md.SetDecoders(self.Decoders)
+		}
 	}
 }
 
@@ -112,6 +195,9 @@ func (self *PipelinePack) Zero() {
 	self.Decoded = false
 	self.Blocked = false
 	self.FilterChain = self.Config.DefaultFilterChain
+	self.RetryCount = 0
+	self.LastError = nil
+	self.InputName = ""
 	for outputName, _ := range self.OutputNames {
 		delete(self.OutputNames, outputName)
 	}
@@ -131,6 +217,7 @@ func filterProcessor(pipelinePack *PipelinePack) {
 		log.Printf("Filter chain doesn't exist: %s", filterChainName)
 		return
 	}
+	filterChainMatchCounts.Add(filterChainName, 1)
 	for _, outputName := range filterChain.Outputs {
 		pipelinePack.OutputNames[outputName] = true
 	}
@@ -143,6 +230,31 @@ func filterProcessor(pipelinePack *PipelinePack) {
 	}
 }
 
+// deliverToDeadLetter routes a pack that exhausted its retries to the
+// dead letter output configured on its filter chain, if any. This is the
+// standard pattern for handling a transient downstream outage (a Kafka
+// broker down, ElasticSearch returning 429s, ...) without silently
+// dropping the pack.
+func deliverToDeadLetter(config *PipelineConfig, ctx context.Context,
+	pack *PipelinePack, failedOutput string, cause error) {
+
+	filterChain, ok := config.FilterChains[pack.FilterChain]
+	if !ok || filterChain.DeadLetter == "" {
+		log.Printf("Output %s exhausted retries, no dead letter output "+
+			"configured, dropping pack: %s\n", failedOutput, cause)
+		return
+	}
+	deadLetter, ok := pack.Outputs[filterChain.DeadLetter]
+	if !ok {
+		log.Printf("Dead letter output doesn't exist: %s\n", filterChain.DeadLetter)
+		return
+	}
+	if err := deadLetter.Deliver(ctx, pack); err != nil {
+		log.Printf("Dead letter output %s also failed: %s\n",
+			filterChain.DeadLetter, err)
+	}
+}
+
 func BroadcastEvent(config *PipelineConfig, eventType string) {
 	err := notify.Post(eventType, nil)
 	if err != nil {
@@ -162,14 +274,56 @@ func BroadcastEvent(config *PipelineConfig, eventType string) {
 	}
 }
 
+// SendPluginEvent delivers an event to a single named plugin's global
+// instead of broadcasting to every Filter and Output, so the admin RPC
+// server can target a reload at just the plugin that changed.
+func SendPluginEvent(config *PipelineConfig, name string, eventType string) error {
+	if wrapper, ok := config.Filters[name]; ok {
+		if wrapper.global != nil {
+			wrapper.global.Event(eventType)
+		}
+		return nil
+	}
+	if wrapper, ok := config.Outputs[name]; ok {
+		if wrapper.global != nil {
+			wrapper.global.Event(eventType)
+		}
+		return nil
+	}
+	return errors.New("no such plugin: " + name)
+}
+
 func Run(config *PipelineConfig) {
 	log.Println("Starting hekad...")
 
 	// Used for recycling PipelinePack objects
 	recycleChan := make(chan *PipelinePack, config.PoolSize+1)
 
-	// Main pipeline function, inputs spawn a goroutine of this for every
-	// message
+	// Packs flow from inputs into the worker pool through here instead of
+	// each input spawning its own goroutine. A full channel naturally
+	// back-pressures inputs once every worker is busy.
+	pipelineChan := make(chan *PipelinePack, config.PoolSize+1)
+
+	maxProcs := config.MaxProcs
+	if maxProcs <= 0 {
+		maxProcs = DefaultMaxProcs
+	}
+
+	queueDepth := expvar.NewInt("PipelineQueueDepth")
+	expvar.Publish("PipelinePoolSize", expvar.Func(func() interface{} { return maxProcs }))
+	stats := expvar.NewMap("PipelineWorkerStats")
+
+	// Cancelled once the shutdown drain deadline passes, so batching
+	// outputs can abandon a delivery in flight instead of blocking forever.
+	outputCtx, cancelOutputs := context.WithCancel(context.Background())
+
+	retryLimit := config.RetryLimit
+	if retryLimit <= 0 {
+		retryLimit = DefaultRetryLimit
+	}
+
+	// Main pipeline function; each worker calls this once per pack pulled
+	// off pipelineChan.
 	pipeline := func(pack *PipelinePack) {
 
 		// When finished, reset and recycle the allocated PipelinePack
@@ -201,7 +355,9 @@ func Run(config *PipelineConfig) {
 			return
 		}
 
-		// Deliver message to appropriate outputs
+		// Deliver message to appropriate outputs, retrying transient
+		// failures with exponential backoff before falling back to the
+		// chain's dead letter output (if any).
 		for outputName, use := range pack.OutputNames {
 			if !use {
 				continue
@@ -211,7 +367,35 @@ func Run(config *PipelineConfig) {
 				log.Printf("Output doesn't exist: %s\n", outputName)
 				continue
 			}
-			output.Deliver(pack)
+
+			var err error
+		retryLoop:
+			for pack.RetryCount = 0; pack.RetryCount <= retryLimit; pack.RetryCount++ {
+				if pack.RetryCount > 0 {
+					select {
+					case <-time.After(retryBackoff(pack.RetryCount)):
+					case <-outputCtx.Done():
+						// Shutdown cancelled outputs; stop waiting out the
+						// backoff and fall straight through to the dead
+						// letter handling below instead of sleeping past it.
+						err = outputCtx.Err()
+						break retryLoop
+					}
+				}
+				if err = output.Deliver(outputCtx, pack); err == nil {
+					break retryLoop
+				}
+				log.Printf("Output %s delivery failed (attempt %d/%d): %s\n",
+					outputName, pack.RetryCount+1, retryLimit+1, err)
+			}
+			if err == nil {
+				outputDeliveryCounts.Add(outputName, 1)
+				continue
+			}
+
+			outputErrorCounts.Add(outputName, 1)
+			pack.LastError = err
+			deliverToDeadLetter(config, outputCtx, pack, outputName, err)
 		}
 	}
 
@@ -220,6 +404,28 @@ func Run(config *PipelineConfig) {
 		recycleChan <- NewPipelinePack(config)
 	}
 
+	var workerWg sync.WaitGroup
+	for i := 0; i < maxProcs; i++ {
+		workerName := "PoolWorker-" + strconv.Itoa(i)
+		ws := &workerStats{}
+		stats.Set(workerName, ws)
+		workerWg.Add(1)
+		go func() {
+			defer workerWg.Done()
+			for pack := range pipelineChan {
+				queueDepth.Add(-1)
+				ws.markBusy()
+				name := pack.InputName
+				if name == "" {
+					name = "unknown"
+				}
+				inputMessageCounts.Add(name, 1)
+				pipeline(pack)
+				ws.markIdle()
+			}
+		}()
+	}
+
 	var wg sync.WaitGroup
 	var runner *InputRunner
 	timeout := time.Duration(time.Second / 2)
@@ -229,26 +435,95 @@ func Run(config *PipelineConfig) {
 		input := wrapper.Create().(Input)
 		runner = &InputRunner{name, input, &timeout}
 		inputRunners[name] = runner
-		runner.Start(pipeline, recycleChan, &wg)
+		runner.Start(pipelineChan, queueDepth, recycleChan, &wg)
 		wg.Add(1)
 		log.Printf("Input started: %s\n", name)
 	}
 
+	reload := &reloader{
+		configPath:   config.ConfigPath,
+		recycleChan:  recycleChan,
+		pipelineChan: pipelineChan,
+		queueDepth:   queueDepth,
+		inputRunners: inputRunners,
+		wg:           &wg,
+		timeout:      &timeout,
+	}
+
 	// wait for sigint
-	sigChan := make(chan os.Signal)
+	// Buffered: signal.Notify delivers non-blocking, so an unbuffered
+	// channel can drop a signal that arrives while nothing is reading
+	// (e.g. the window between break sigListener and the drain select
+	// below), silently defeating the second-SIGINT force-exit path. The
+	// admin Stop RPC also writes into this channel (see below), so the
+	// buffer needs to absorb that on top of a real second SIGINT.
+	sigChan := make(chan os.Signal, 2)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGHUP)
+
+	if config.AdminAddr != "" {
+		admin, err := StartAdminServer(&config, reload, config.AdminAddr, sigChan)
+		if err != nil {
+			log.Printf("Error starting admin server on %s: %s\n", config.AdminAddr, err)
+		} else {
+			defer admin.Close()
+			log.Printf("Admin server listening on %s\n", config.AdminAddr)
+		}
+	}
 sigListener:
 	for {
 		sig := <-sigChan
 		switch sig {
 		case syscall.SIGHUP:
-			BroadcastEvent(config, RELOAD)
+			if err := reload.Reload(&config); err != nil {
+				log.Printf("Reload error: %s\n", err)
+			}
 		case syscall.SIGINT:
 			BroadcastEvent(config, STOP)
 			break sigListener
 		}
 	}
+	// From here on anything arriving on sigChan forces exit, so stop
+	// delivering SIGHUP to it: only a second SIGINT (or admin Stop) counts.
+	signal.Ignore(syscall.SIGHUP)
+
+	// Phase one: stop accepting new messages. Inputs get a chance to close
+	// their listening sockets cleanly before we wait on in-flight packs.
+	log.Println("Draining in-flight packs...")
+	for name, runner := range inputRunners {
+		runner.Stop()
+		log.Printf("Input stopped: %s\n", name)
+	}
+
+	shutdownTimeout := config.ShutdownTimeout
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = DefaultShutdownTimeout
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(pipelineChan)
+		workerWg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		log.Println("All in-flight packs delivered.")
+	case <-time.After(shutdownTimeout):
+		log.Printf("Shutdown timeout (%s) exceeded, cancelling outputs.\n",
+			shutdownTimeout)
+		cancelOutputs()
+		select {
+		case <-drained:
+		case <-sigChan:
+			log.Println("Second SIGINT received, forcing immediate exit.")
+			os.Exit(1)
+		}
+	case <-sigChan:
+		log.Println("Second SIGINT received, forcing immediate exit.")
+		os.Exit(1)
+	}
 
-	wg.Wait()
 	log.Println("Shutdown complete.")
 }
\ No newline at end of file